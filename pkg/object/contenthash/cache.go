@@ -0,0 +1,152 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package contenthash
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/object/contenthash/digest"
+)
+
+// sidecarFile is the name the persisted cache is written under, inside
+// object.SidecarDir. It lives alongside the synced tree rather than in a
+// system-wide location so that the cache travels with `juicesync`'s own
+// bookkeeping and is easy to blow away with the rest of it.
+const sidecarFile = "contenthash.cache"
+
+// persistEvery bounds how many set calls accumulate before store writes the
+// whole tree back out, trading a larger crash-loss window (at most this
+// many updates) for not re-serializing and renaming the entire cache file
+// on every single file checksummed — Checksum already calls set once per
+// file plus once per ancestor directory, so a first scan of a large tree
+// would otherwise be an O(N^2) pile of full-cache rewrites.
+const persistEvery = 256
+
+// entry is what gets persisted for a radix key. ModTime/Size are only
+// meaningful for file entries: a directory's contents entry is invalidated
+// explicitly (see invalidateAncestors) rather than by stat, since a
+// directory's own mtime is not a reliable signal of its content changing.
+// Listing is only meaningful for a directory's contents entry: it's the
+// digest of that directory's own (non-recursive) entry names at the time
+// Digest was computed, used to catch an entry having been added or removed
+// since — the one change invalidateAncestors can't see coming, since it
+// only runs when an existing file is modified.
+type entry struct {
+	Digest  digest.Digest
+	ModTime int64
+	Size    int64
+	Listing digest.Digest
+}
+
+// store wraps an immutable radix tree with the mutex and sidecar file needed
+// to use it as a mutable, persistent cache. Readers hold the mutex only
+// long enough to pick up the current root, then read that consistent
+// snapshot without it (iradix.Tree is immutable); writers hold it while
+// swapping the root pointer.
+type store struct {
+	root string // directory this cache is rooted at
+
+	mu     sync.Mutex
+	tree   *iradix.Tree
+	dirty  bool // tree has updates persistLocked hasn't written out yet
+	writes int  // set calls since the last persist, for persistEvery
+}
+
+func loadStore(root string) (*store, error) {
+	s := &store{root: root, tree: iradix.New()}
+	f, err := os.Open(filepath.Join(root, object.SidecarDir, sidecarFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries map[string]entry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	txn := s.tree.Txn()
+	for k, v := range entries {
+		txn.Insert([]byte(k), v)
+	}
+	s.tree = txn.Commit()
+	return s, nil
+}
+
+func (s *store) get(key string) (entry, bool) {
+	s.mu.Lock()
+	tree := s.tree
+	s.mu.Unlock()
+	v, ok := tree.Get([]byte(key))
+	if !ok {
+		return entry{}, false
+	}
+	return v.(entry), true
+}
+
+// set inserts or replaces key, persisting the resulting snapshot only every
+// persistEvery calls (see its doc comment). Call flush to persist the rest
+// once the caller is done making updates.
+func (s *store) set(key string, e entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree, _, _ = s.tree.Insert([]byte(key), e)
+	s.dirty = true
+	s.writes++
+	if s.writes < persistEvery {
+		return nil
+	}
+	s.writes = 0
+	return s.persistLocked()
+}
+
+// flush persists the tree if set has made updates since the last persist.
+func (s *store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	s.writes = 0
+	return s.persistLocked()
+}
+
+// persistLocked writes the whole tree to the sidecar file via a temp file
+// plus rename, so a crash mid-write can never leave a corrupt cache behind.
+// Must be called with s.mu held.
+func (s *store) persistLocked() error {
+	dir := filepath.Join(s.root, object.SidecarDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	entries := make(map[string]entry)
+	s.tree.Root().Walk(func(k []byte, v interface{}) bool {
+		entries[string(k)] = v.(entry)
+		return false
+	})
+
+	tmp, err := ioutil.TempFile(dir, sidecarFile+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, sidecarFile)); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,312 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+// Package contenthash maintains a persistent, path-keyed cache of SHA256
+// digests for a local store, so that repeated `juicefs sync` runs between
+// the same SRC and DST can skip re-reading files (and whole subtrees) whose
+// content hasn't changed since the last run.
+//
+// The cache is backed by an immutable radix tree (hashicorp/go-immutable-
+// radix): every update produces a new tree sharing structure with the old
+// one, so concurrent readers never observe a half-written tree and the
+// whole thing can be snapshotted to disk cheaply. One kind of key lives in
+// the tree:
+//
+//   - a file's own path, e.g. "/a/b/file", holding the digest of its bytes;
+//   - a directory's path, e.g. "/a/b", holding a digest that folds in every
+//     entry's header+contents digest, recursively.
+//
+// A directory's header digest (mode, xattrs and, if it's a symlink, its
+// target) is cheap enough to recompute on every lookup that it isn't
+// persisted at all; only its contents digest is. That split still lets
+// callers answer "is this whole subtree identical" with a single lookup of
+// the contents key, without descending into it.
+package contenthash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/object/contenthash/digest"
+)
+
+// xattrFS is the subset of object.LocalPathFS the cache needs to fold
+// extended attributes into a directory's header digest. Backends that
+// don't map onto real paths (memFS, overlayFS) simply don't implement it,
+// and headerDigest skips xattrs for them.
+type xattrFS interface {
+	RealPath(name string) string
+}
+
+// CacheContext answers digest queries against a single ObjectStorage,
+// transparently caching and invalidating as the underlying files change.
+type CacheContext interface {
+	// Checksum returns the digest of the file stored at key. Files are
+	// invalidated lazily: the cached digest is reused as long as the file's
+	// mtime and size haven't changed since it was computed.
+	Checksum(ctx context.Context, key string) (digest.Digest, error)
+
+	// ChecksumTree returns a digest for the directory at prefix that folds
+	// in the header and contents digest of every entry beneath it,
+	// recursively. Two directories with the same ChecksumTree digest are
+	// guaranteed to have identical contents.
+	ChecksumTree(ctx context.Context, prefix string) (digest.Digest, error)
+
+	// Entries returns the sorted, sidecar-filtered names directly under
+	// prefix (see ChecksumTree, which walks the same listing), and whether
+	// prefix is a directory at all. A caller that finds two ChecksumTree
+	// digests differ and needs to narrow down which child actually changed,
+	// rather than re-hashing the whole subtree, descends with this instead
+	// of reimplementing ChecksumTree's own Readdir+sidecar-filter logic.
+	Entries(prefix string) (names []string, isDir bool, err error)
+
+	// Close persists any cache updates Checksum/ChecksumTree have batched up
+	// but not yet written out. Callers should defer it right after
+	// GetCacheContext so a run's updates aren't lost to batching.
+	Close() error
+}
+
+// localStore is implemented by ObjectStorage backends that are themselves
+// a directory tree exposed through an object.FS, letting the cache resolve
+// a key to a path within that FS for stat-based invalidation. *filestore is
+// the only implementation today, but it works the same whether that
+// filestore is backed by the real disk, memFS or an overlayFS.
+type localStore interface {
+	Root() string
+	FS() object.FS
+}
+
+// GetCacheContext returns a CacheContext for store, loading its persisted
+// cache (if any) from the store's ".juicesync/" sidecar directory. store
+// must be a *filestore (today that means a `file://`, `mem://` or
+// `overlay://` store). The cache file is read and written with the host's
+// own os calls at that path under store's Root, whatever FS backs store.
+func GetCacheContext(store object.ObjectStorage) (CacheContext, error) {
+	r, ok := store.(localStore)
+	if !ok {
+		return nil, fmt.Errorf("contenthash: %s has no local root to cache against", store)
+	}
+	s, err := loadStore(r.Root())
+	if err != nil {
+		return nil, fmt.Errorf("contenthash: load cache for %s: %s", store, err)
+	}
+	return &cacheContext{root: r.Root(), fs: r.FS(), store: s}, nil
+}
+
+type cacheContext struct {
+	root  string
+	fs    object.FS
+	store *store
+}
+
+func (c *cacheContext) path(key string) string {
+	return filepath.Join(c.root, cleanKey(key))
+}
+
+func (c *cacheContext) Close() error {
+	return c.store.flush()
+}
+
+func (c *cacheContext) Checksum(ctx context.Context, key string) (digest.Digest, error) {
+	key = cleanKey(key)
+	fi, err := c.fs.Stat(c.path(key))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if fi.IsDir() {
+		return digest.Digest{}, fmt.Errorf("contenthash: %s is a directory, use ChecksumTree", key)
+	}
+
+	if e, ok := c.store.get(key); ok && e.ModTime == fi.ModTime().UnixNano() && e.Size == fi.Size() {
+		return e.Digest, nil
+	}
+
+	f, err := c.fs.Open(c.path(key))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	d, err := digest.Sum(f)
+	f.Close()
+	if err != nil {
+		return digest.Digest{}, err
+	}
+
+	if err := c.store.set(key, entry{Digest: d, ModTime: fi.ModTime().UnixNano(), Size: fi.Size()}); err != nil {
+		return digest.Digest{}, err
+	}
+	if err := c.invalidateAncestors(key); err != nil {
+		return digest.Digest{}, err
+	}
+	return d, nil
+}
+
+// invalidateAncestors clears the cached contents digest of every ancestor
+// directory of key, since a change to key may have changed what any of them
+// recursively contain. The header digest isn't persisted in the first place
+// (see headerDigest), so there's nothing to invalidate there.
+func (c *cacheContext) invalidateAncestors(key string) error {
+	dir := parentOf(key)
+	for dir != "" {
+		e, ok := c.store.get(contentsKey(dir))
+		if !ok || e.Digest.IsZero() {
+			// Already uncached: every ancestor above it is too.
+			break
+		}
+		if err := c.store.set(contentsKey(dir), entry{}); err != nil {
+			return err
+		}
+		if dir == "/" {
+			break
+		}
+		dir = parentOf(dir)
+	}
+	return nil
+}
+
+// Entries implements CacheContext.Entries.
+func (c *cacheContext) Entries(prefix string) ([]string, bool, error) {
+	prefix = cleanKey(prefix)
+	fi, err := c.fs.Stat(c.path(prefix))
+	if err != nil {
+		return nil, false, err
+	}
+	if !fi.IsDir() {
+		return nil, false, nil
+	}
+	entries, err := c.fs.Readdir(c.path(prefix))
+	if err != nil {
+		return nil, false, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if object.IsSidecar(e.Name()) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names, true, nil
+}
+
+// joinKey appends name as a child of prefix, both assumed already cleaned.
+func joinKey(prefix, name string) string {
+	if prefix == "/" {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// verifyUnchanged reports whether every child of prefix is still exactly
+// as it was when contentsKey(prefix)'s cached digest was computed: each
+// file's mtime/size still matches its own cached entry, and each
+// subdirectory recursively passes the same check. This is what closes the
+// gap an unchanged entry *listing* alone leaves open — editing a file in
+// place changes neither prefix's entry names nor (necessarily) any
+// ancestor's mtime, so the listing digest can't catch it by itself.
+func (c *cacheContext) verifyUnchanged(prefix string, names []string) (bool, error) {
+	for _, name := range names {
+		key := joinKey(prefix, name)
+		fi, err := c.fs.Stat(c.path(key))
+		if err != nil {
+			return false, err
+		}
+		if !fi.IsDir() {
+			e, ok := c.store.get(key)
+			if !ok || e.ModTime != fi.ModTime().UnixNano() || e.Size != fi.Size() {
+				return false, nil
+			}
+			continue
+		}
+		childNames, _, err := c.Entries(key)
+		if err != nil {
+			return false, err
+		}
+		e, ok := c.store.get(contentsKey(key))
+		if !ok || e.Digest.IsZero() {
+			return false, nil
+		}
+		if e.Listing != digest.SumBytes([]byte(strings.Join(childNames, "\x00"))) {
+			return false, nil
+		}
+		if unchanged, err := c.verifyUnchanged(key, childNames); err != nil || !unchanged {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (c *cacheContext) ChecksumTree(ctx context.Context, prefix string) (digest.Digest, error) {
+	prefix = cleanKey(prefix)
+	fi, err := c.fs.Stat(c.path(prefix))
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	if !fi.IsDir() {
+		return c.Checksum(ctx, prefix)
+	}
+
+	header, err := c.headerDigest(prefix, fi)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+
+	names, _, err := c.Entries(prefix)
+	if err != nil {
+		return digest.Digest{}, err
+	}
+	// Cheap, listing-only signal that something was added to or removed
+	// from prefix since its contents digest was cached: a directory's own
+	// mtime isn't a reliable one (see cache.go), but its entry set is.
+	listing := digest.SumBytes([]byte(strings.Join(names, "\x00")))
+
+	if e, ok := c.store.get(contentsKey(prefix)); ok && !e.Digest.IsZero() && e.Listing == listing {
+		if unchanged, err := c.verifyUnchanged(prefix, names); err != nil {
+			return digest.Digest{}, err
+		} else if unchanged {
+			return digest.Combine(header[:], e.Digest[:]), nil
+		}
+		// Some descendant was edited in place (same name, different
+		// content): the listing alone can't see that, so fall through and
+		// recompute for real rather than trusting a stale digest.
+	}
+
+	h := make([][]byte, 0, len(names)+1)
+	for _, name := range names {
+		d, err := c.ChecksumTree(ctx, joinKey(prefix, name))
+		if err != nil {
+			return digest.Digest{}, err
+		}
+		h = append(h, []byte(name), d[:])
+	}
+	contents := digest.Combine(h...)
+	if err := c.store.set(contentsKey(prefix), entry{Digest: contents, Listing: listing}); err != nil {
+		return digest.Digest{}, err
+	}
+	return digest.Combine(header[:], contents[:]), nil
+}
+
+// headerDigest hashes a directory's mode, xattrs and (if applicable)
+// symlink target. Unlike the contents digest it is cheap enough to
+// recompute on every lookup rather than cache.
+func (c *cacheContext) headerDigest(key string, fi os.FileInfo) (digest.Digest, error) {
+	parts := [][]byte{[]byte(fi.Mode().String())}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := c.fs.Readlink(c.path(key))
+		if err != nil {
+			return digest.Digest{}, err
+		}
+		parts = append(parts, []byte(target))
+	}
+	if local, ok := c.fs.(xattrFS); ok {
+		xattrs, err := listXattrs(local.RealPath(c.path(key)))
+		if err != nil {
+			return digest.Digest{}, err
+		}
+		parts = append(parts, xattrs...)
+	}
+	return digest.Combine(parts...), nil
+}
@@ -0,0 +1,199 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package contenthash_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/object/contenthash"
+)
+
+func newMemStore(t *testing.T) object.ObjectStorage {
+	t.Helper()
+	store, err := object.CreateStorage("mem", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateStorage(mem): %s", err)
+	}
+	return store
+}
+
+func newReader(s string) *strings.Reader {
+	return strings.NewReader(s)
+}
+
+func TestChecksumInvalidatesOnContentChange(t *testing.T) {
+	store := newMemStore(t)
+	if err := store.Put("/a", newReader("hello")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	cc, err := contenthash.GetCacheContext(store)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer cc.Close()
+
+	d1, err := cc.Checksum(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("Checksum: %s", err)
+	}
+	d2, err := cc.Checksum(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("Checksum (cached): %s", err)
+	}
+	if d1 != d2 {
+		t.Fatalf("unchanged file got a different digest on re-checksum: %v != %v", d1, d2)
+	}
+
+	if err := store.Put("/a", newReader("goodbye")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	// mtime alone may not have advanced past the resolution the cache keys
+	// off, so bump it forward explicitly to exercise the mtime check rather
+	// than the size check.
+	mtimeSetter, ok := store.(interface {
+		Chtimes(path string, mtime time.Time) error
+	})
+	if ok {
+		_ = mtimeSetter.Chtimes("/a", time.Now().Add(time.Hour))
+	}
+	d3, err := cc.Checksum(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("Checksum (after change): %s", err)
+	}
+	if d3 == d1 {
+		t.Fatalf("changed file kept its old digest: cache failed to invalidate")
+	}
+}
+
+func TestChecksumTreeDetectsAddedFile(t *testing.T) {
+	store := newMemStore(t)
+	if err := store.Put("/dir/a", newReader("hello")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	cc, err := contenthash.GetCacheContext(store)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer cc.Close()
+
+	before, err := cc.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree: %s", err)
+	}
+
+	if err := store.Put("/dir/b", newReader("world")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	after, err := cc.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree (after add): %s", err)
+	}
+	if before == after {
+		t.Fatalf("adding a file to the directory didn't change its tree digest")
+	}
+}
+
+func TestChecksumTreeMatchesIdenticalTrees(t *testing.T) {
+	a := newMemStore(t)
+	b := newMemStore(t)
+	for _, s := range []object.ObjectStorage{a, b} {
+		if err := s.Put("/dir/a", newReader("hello")); err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+		if err := s.Put("/dir/sub/b", newReader("world")); err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+
+	ccA, err := contenthash.GetCacheContext(a)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer ccA.Close()
+	ccB, err := contenthash.GetCacheContext(b)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer ccB.Close()
+
+	dA, err := ccA.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree(a): %s", err)
+	}
+	dB, err := ccB.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree(b): %s", err)
+	}
+	if dA != dB {
+		t.Fatalf("two identical trees got different digests: %v != %v", dA, dB)
+	}
+}
+
+func TestChecksumTreeDetectsInPlaceEdit(t *testing.T) {
+	store := newMemStore(t)
+	if err := store.Put("/dir/a", newReader("hello")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	cc, err := contenthash.GetCacheContext(store)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer cc.Close()
+
+	before, err := cc.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree: %s", err)
+	}
+
+	if err := store.Put("/dir/a", newReader("CHANGED-CONTENT")); err != nil {
+		t.Fatalf("Put (changed): %s", err)
+	}
+	mtimeSetter, ok := store.(interface {
+		Chtimes(path string, mtime time.Time) error
+	})
+	if ok {
+		_ = mtimeSetter.Chtimes("/dir/a", time.Now().Add(time.Hour))
+	}
+
+	after, err := cc.ChecksumTree(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("ChecksumTree (after edit): %s", err)
+	}
+	if before == after {
+		t.Fatalf("editing a file in place (same name, different content) kept the directory's tree digest unchanged")
+	}
+}
+
+func TestEntriesSkipsSidecars(t *testing.T) {
+	store := newMemStore(t)
+	if err := store.Put("/dir/a", newReader("hello")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	if err := store.Put("/dir/a"+object.ManifestSuffix, newReader("{}")); err != nil {
+		t.Fatalf("Put manifest: %s", err)
+	}
+	if err := store.Put("/dir/"+object.SidecarDir+"/cache", newReader("x")); err != nil {
+		t.Fatalf("Put sidecar: %s", err)
+	}
+
+	cc, err := contenthash.GetCacheContext(store)
+	if err != nil {
+		t.Fatalf("GetCacheContext: %s", err)
+	}
+	defer cc.Close()
+
+	names, isDir, err := cc.Entries("/dir")
+	if err != nil {
+		t.Fatalf("Entries: %s", err)
+	}
+	if !isDir {
+		t.Fatalf("/dir reported as not a directory")
+	}
+	if len(names) != 1 || names[0] != "a" {
+		t.Fatalf("Entries returned %v, want [a] (manifest and sidecar filtered out)", names)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+// Package digest defines the fixed-size SHA256 digest type shared by the
+// contenthash cache, along with the helpers needed to hash, compare and
+// (de)serialize it.
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// Size is the length in bytes of a Digest.
+const Size = sha256.Size
+
+// Digest is a SHA256 content digest.
+type Digest [Size]byte
+
+// String returns the lowercase hex encoding of d.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// IsZero reports whether d is the zero digest.
+func (d Digest) IsZero() bool {
+	return d == Digest{}
+}
+
+// Sum hashes r and returns its digest.
+func Sum(r io.Reader) (Digest, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return Digest{}, err
+	}
+	var d Digest
+	copy(d[:], h.Sum(nil))
+	return d, nil
+}
+
+// SumBytes hashes b and returns its digest.
+func SumBytes(b []byte) Digest {
+	return Digest(sha256.Sum256(b))
+}
+
+// Combine hashes the concatenation of the given digests (and any extra byte
+// slices), in order, into a single digest. It is used to fold a directory's
+// entry digests into one contents digest.
+func Combine(parts ...[]byte) Digest {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var d Digest
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+// Parse decodes a hex-encoded digest produced by String.
+func Parse(s string) (Digest, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Digest{}, err
+	}
+	var d Digest
+	if len(b) != Size {
+		return d, io.ErrUnexpectedEOF
+	}
+	copy(d[:], b)
+	return d, nil
+}
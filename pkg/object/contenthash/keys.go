@@ -0,0 +1,38 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package contenthash
+
+import "path"
+
+// cleanKey normalizes key into a cleaned, absolute-looking unix path (always
+// starting with "/", never ending with "/") so that radix lookups are stable
+// regardless of how the caller spelled the key.
+func cleanKey(key string) string {
+	if key == "" {
+		return "/"
+	}
+	if key[0] != '/' {
+		key = "/" + key
+	}
+	clean := path.Clean(key)
+	if clean != "/" && clean[len(clean)-1] == '/' {
+		clean = clean[:len(clean)-1]
+	}
+	return clean
+}
+
+// contentsKey returns the radix key holding a directory's recursive contents
+// digest.
+func contentsKey(dir string) string {
+	return dir
+}
+
+// parentOf returns the cleaned parent directory of key, or "" if key is
+// already the root.
+func parentOf(key string) string {
+	if key == "/" {
+		return ""
+	}
+	p := path.Dir(key)
+	return p
+}
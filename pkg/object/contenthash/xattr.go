@@ -0,0 +1,32 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package contenthash
+
+import (
+	"sort"
+
+	"github.com/pkg/xattr"
+)
+
+// listXattrs returns name/value pairs for every extended attribute on path,
+// sorted by name so the resulting digest is independent of on-disk order.
+func listXattrs(path string) ([][]byte, error) {
+	names, err := xattr.LList(path)
+	if err != nil {
+		if xattr.IsNotExist(err) || err == xattr.ErrNotSupported {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(names)
+
+	parts := make([][]byte, 0, len(names)*2)
+	for _, name := range names {
+		v, err := xattr.LGet(path, name)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, []byte(name), v)
+	}
+	return parts, nil
+}
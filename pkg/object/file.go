@@ -0,0 +1,586 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	dirSuffix = "/"
+
+	// SidecarDir is the directory, relative to any filestore root, that
+	// sync's own bookkeeping lives under (today: the contenthash cache —
+	// see contenthash.GetCacheContext). Walk, List and
+	// contenthash.ChecksumTree all skip it via IsSidecar, so it's never
+	// treated as data to sync.
+	SidecarDir = ".juicesync"
+
+	// ManifestSuffix marks a key as a chunk.SyncFile manifest (see
+	// pkg/sync/chunk) rather than synced data; Walk, List and
+	// contenthash.ChecksumTree skip those the same way they skip
+	// SidecarDir.
+	ManifestSuffix = ".chunks.json"
+)
+
+// IsSidecar reports whether name — a path component as seen by Walk and
+// Readdir, or a full object key as seen by List — refers to sync's own
+// bookkeeping rather than data the user asked to sync.
+func IsSidecar(name string) bool {
+	return name == SidecarDir || strings.HasSuffix(name, ManifestSuffix)
+}
+
+// FollowSymlinks controls how filestore.Walk (and therefore List, and
+// anything built on it such as sync) treats symbolic links found under the
+// store's root.
+type FollowSymlinks int
+
+const (
+	// SymlinksSafe follows symlinks but never leaves root: a link that
+	// resolves outside root is reported as a broken link rather than
+	// traversed. This is the default.
+	SymlinksSafe FollowSymlinks = iota
+	// SymlinksNone never follows symlinks; they are reported as symlinks
+	// and never descended into, even if they point at a directory.
+	SymlinksNone
+	// SymlinksUnsafe follows symlinks unconditionally, including ones that
+	// escape root. Kept for back-compat with the pre-existing behaviour.
+	SymlinksUnsafe
+)
+
+// ParseFollowSymlinks parses the --symlinks={safe,none,unsafe} flag value.
+func ParseFollowSymlinks(s string) (FollowSymlinks, error) {
+	switch s {
+	case "", "safe":
+		return SymlinksSafe, nil
+	case "none":
+		return SymlinksNone, nil
+	case "unsafe":
+		return SymlinksUnsafe, nil
+	default:
+		return SymlinksSafe, fmt.Errorf("invalid --symlinks value %q, must be one of safe, none, unsafe", s)
+	}
+}
+
+type filestore struct {
+	defaultObjectStorage
+	fs         FS
+	root       string
+	follow     FollowSymlinks
+	lastListed string
+	listing    chan *Object
+	listerr    error
+}
+
+// SetFollowSymlinks configures how this store's Walk (and List) handle
+// symlinks under root. It is exposed as a setter, rather than a newDisk
+// parameter, so that callers who only have an ObjectStorage (as returned by
+// CreateStorage) can opt in via a type assertion, the same way `--perms`
+// does for object.FileSystem.
+func (d *filestore) SetFollowSymlinks(mode FollowSymlinks) {
+	d.follow = mode
+}
+
+func (d *filestore) String() string {
+	return "file://" + d.root
+}
+
+func (d *filestore) path(key string) string {
+	return filepath.Join(d.root, key)
+}
+
+// Root returns the local directory this store is rooted at, so that callers
+// needing direct filesystem access (e.g. the contenthash cache) can resolve
+// keys to real paths without going through Get/Put.
+func (d *filestore) Root() string {
+	return d.root
+}
+
+// FS returns the filesystem backing this store, so that callers who need to
+// operate on it directly (e.g. the contenthash cache) don't have to
+// reimplement Get/Put's notion of what a key resolves to.
+func (d *filestore) FS() FS {
+	return d.fs
+}
+
+// resolvePath resolves key to a real path under d.root, honoring d.follow
+// the same way Walk/statForWalk does. Get, Put, Copy, Exists and Delete all
+// go through this rather than the bare path(key) they used to: otherwise a
+// symlink under root could redirect a read or write straight through to
+// its target — including outside root, or (under --symlinks=none) to
+// anywhere at all — with none of the confinement Walk/List apply to
+// traversal, even though that target was never listed as a syncable key in
+// its own right. SymlinksUnsafe joins unconditionally, for back-compat.
+func (d *filestore) resolvePath(key string) (string, error) {
+	p := d.path(key)
+	switch d.follow {
+	case SymlinksUnsafe:
+		return p, nil
+	case SymlinksNone:
+		return rejectSymlinkComponents(d.fs, d.root, p)
+	default: // SymlinksSafe
+		resolved, ok, err := secureJoin(d.fs, d.root, p)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("%s escapes root %s", key, d.root)
+		}
+		return resolved, nil
+	}
+}
+
+// rejectSymlinkComponents resolves path (itself somewhere under root) to
+// itself, refusing if any component from root down to path — including
+// path itself, if it exists — is a symlink. Used for --symlinks=none,
+// which promises never to follow a symlink, not even a safely
+// root-confined one.
+func rejectSymlinkComponents(fs FS, root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside root %s", path, root)
+	}
+	var current string
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "" || part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		full := filepath.Join(root, current)
+		lstat, err := fs.Lstat(full)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if lstat.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is, or is reached through, a symlink; refusing under --symlinks=none", path)
+		}
+	}
+	return filepath.Join(root, rel), nil
+}
+
+func (d *filestore) Get(key string, off, limit int64) (io.ReadCloser, error) {
+	p, err := d.resolvePath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if off > 0 {
+		if _, err := f.Seek(off, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if limit > 0 {
+		defer f.Close()
+		buf := make([]byte, limit)
+		if n, err := f.Read(buf); err != nil {
+			return nil, err
+		} else {
+			return ioutil.NopCloser(bytes.NewBuffer(buf[:n])), nil
+		}
+	}
+	return f, err
+}
+
+func (d *filestore) Put(key string, in io.Reader) error {
+	p, err := d.resolvePath(key)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(key, dirSuffix) {
+		return d.fs.MkdirAll(p, os.FileMode(0700))
+	}
+
+	if err := d.fs.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return err
+	}
+	f, err := d.fs.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, in)
+	return err
+}
+
+func (d *filestore) Copy(dst, src string) error {
+	r, err := d.Get(src, 0, -1)
+	if err != nil {
+		return err
+	}
+	return d.Put(dst, r)
+}
+
+func (d *filestore) Exists(key string) error {
+	p, err := d.resolvePath(key)
+	if err != nil {
+		return errors.New("not exists")
+	}
+	if _, err := d.fs.Stat(p); err == nil {
+		return nil
+	}
+	return errors.New("not exists")
+}
+
+func (d *filestore) Delete(key string) error {
+	if d.Exists(key) != nil {
+		return errors.New("not exists")
+	}
+	p, err := d.resolvePath(key)
+	if err != nil {
+		return errors.New("not exists")
+	}
+	return d.fs.Remove(p)
+}
+
+// PatchRange implements RangePatcher by opening dst for read/write, without
+// truncating it, seeking to offset, and overwriting from src: the
+// filesystem equivalent of a server-side ranged copy. dst is created (along
+// with any missing parent directories) if it doesn't exist yet, since the
+// first chunk-sync of a file has nothing at dst to open.
+func (d *filestore) PatchRange(dst string, offset int64, src io.Reader) error {
+	p := d.path(dst)
+	if err := d.fs.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return err
+	}
+	f, err := d.fs.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	return err
+}
+
+// Truncate implements RangePatcher by opening dst (creating it if it
+// doesn't exist, so a zero-length source still produces an empty dst) and
+// truncating it to size.
+func (d *filestore) Truncate(dst string, size int64) error {
+	p := d.path(dst)
+	if err := d.fs.MkdirAll(filepath.Dir(p), os.FileMode(0700)); err != nil {
+		return err
+	}
+	f, err := d.fs.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+// maxSymlinkHops bounds how many chained symlinks secureJoin will follow
+// while resolving a single path, mirroring the kernel's own ELOOP limit.
+const maxSymlinkHops = 40
+
+// secureJoin resolves path (itself somewhere under root) to a real,
+// root-confined path, the way a kernel would: every component from root
+// down to path is Lstat'd and, if it's a symlink, expanded and
+// re-validated in turn — including components of that symlink's own
+// target, however many hops deep. That's what stops a chain of two or
+// more independent symlinks (e.g. root/a -> b, root/b -> /etc) from
+// adding up to an escape that checking path's own readlink target in
+// isolation would miss. An absolute target under root is rewritten
+// relative to root (a link to root/real resolves to root/real). ok is
+// false if resolution would leave root at any point (an absolute target
+// outside root, or a ".." climbing above root itself), in which case the
+// caller should treat the entry as a broken link rather than traverse it. Adapted from the
+// algorithm behind cyphar/filepath-securejoin.
+func secureJoin(fs FS, root, path string) (resolved string, ok bool, err error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, nil
+	}
+
+	var current string // root-relative path resolved so far; "" means root itself
+	remaining := rel
+	linksWalked := 0
+	for remaining != "" {
+		var part string
+		if i := strings.IndexRune(remaining, filepath.Separator); i == -1 {
+			part, remaining = remaining, ""
+		} else {
+			part, remaining = remaining[:i], remaining[i+1:]
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." && current == "" {
+			return "", false, nil // would climb above root
+		}
+		// Joining against the separator lets Join's Clean collapse a ".."
+		// that stays inside root.
+		next := filepath.Join(string(filepath.Separator), current, part)
+		if next == string(filepath.Separator) {
+			current = ""
+			continue
+		}
+		next = next[1:]
+
+		lstat, err := fs.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			current = next
+			continue
+		} else if err != nil {
+			return "", false, err
+		}
+		if lstat.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		linksWalked++
+		if linksWalked > maxSymlinkHops {
+			return "", false, fmt.Errorf("secureJoin: too many levels of symbolic links resolving %s", path)
+		}
+		dest, err := fs.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", false, err
+		}
+		if filepath.IsAbs(dest) {
+			r, err := filepath.Rel(root, dest)
+			if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+				return "", false, nil // absolute target outside root
+			}
+			current = "" // left unadvanced above, so this just anchors back at root
+			dest = r
+		}
+		remaining = dest + string(filepath.Separator) + remaining
+	}
+	return filepath.Join(root, current), true, nil
+}
+
+// walk recursively descends path, calling walkFn. visited tracks the inodes
+// of directories already descended into (via a followed symlink) during
+// this walk, to break cycles.
+func (d *filestore) walk(path string, info os.FileInfo, visited map[uint64]struct{}, walkFn filepath.WalkFunc) error {
+	err := walkFn(path, info, nil)
+	if err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		return nil
+	}
+
+	names, err := d.readDirNames(path)
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		lstat, err := d.fs.Lstat(filename)
+		if err != nil {
+			if err := walkFn(filename, lstat, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		fileInfo, err := d.statForWalk(filename, lstat, visited)
+		if err != nil {
+			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if fileInfo == nil {
+			// A symlink that was skipped instead of traversed (SymlinksNone,
+			// or SymlinksSafe escaping root, or a cycle): report it as-is.
+			if err := walkFn(filename, lstat, nil); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		if fileInfo.IsDir() {
+			err = d.walk(filename, fileInfo, visited, walkFn)
+		} else {
+			err = walkFn(filename, fileInfo, nil)
+		}
+		if err != nil {
+			if !fileInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statForWalk decides whether filename (already Lstat'd as lstat) should be
+// traversed as a directory, reported as a plain entry, or skipped according
+// to d.follow. It returns (info, nil) to traverse/report using info,
+// (nil, nil) to report filename as-is without traversing, or a non-nil
+// error if stat-ing the (possibly followed) target failed.
+func (d *filestore) statForWalk(filename string, lstat os.FileInfo, visited map[uint64]struct{}) (os.FileInfo, error) {
+	if lstat.Mode()&os.ModeSymlink == 0 {
+		return lstat, nil
+	}
+	switch d.follow {
+	case SymlinksNone:
+		return nil, nil
+	case SymlinksUnsafe:
+		target, err := d.fs.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		return d.dedupDir(target, visited), nil
+	default: // SymlinksSafe
+		resolved, ok, err := secureJoin(d.fs, d.root, filename)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+		target, err := d.fs.Stat(resolved)
+		if err != nil {
+			return nil, nil // target vanished or is unreadable: report as broken link
+		}
+		return d.dedupDir(target, visited), nil
+	}
+}
+
+// dedupDir returns nil if info is a directory whose inode is already in
+// visited (breaking a symlink cycle), recording it otherwise.
+func (d *filestore) dedupDir(info os.FileInfo, visited map[uint64]struct{}) os.FileInfo {
+	if !info.IsDir() {
+		return info
+	}
+	ino := inodeOf(info)
+	if _, seen := visited[ino]; seen {
+		return nil
+	}
+	visited[ino] = struct{}{}
+	return info
+}
+
+// Walk walks the file tree rooted at the store's root, calling walkFn for
+// each file or directory in the tree, including root. All errors that
+// arise visiting files and directories are filtered by walkFn. The files
+// are walked in lexical order, which makes the output deterministic but
+// means that for very large directories Walk can be inefficient.
+// Symlinks are handled according to d.follow (see FollowSymlinks).
+func (d *filestore) Walk(walkFn filepath.WalkFunc) error {
+	info, err := d.fs.Stat(d.root)
+	if err != nil {
+		err = walkFn(d.root, nil, err)
+	} else {
+		err = d.walk(d.root, info, make(map[uint64]struct{}), walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// readDirNames reads the directory named by dirname and returns a sorted
+// list of directory entries, excluding anything IsSidecar reports true for.
+// A name is suffixed with dirSuffix if it is itself a directory, or a
+// symlink that d.follow would descend into.
+func (d *filestore) readDirNames(dirname string) ([]string, error) {
+	fi, err := d.fs.Readdir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(fi))
+	for i := range fi {
+		if IsSidecar(fi[i].Name()) {
+			continue
+		}
+		isDir := fi[i].IsDir()
+		if !isDir && fi[i].Mode()&os.ModeSymlink != 0 && d.follow != SymlinksNone {
+			full, err := d.statForWalk(filepath.Join(dirname, fi[i].Name()), fi[i], map[uint64]struct{}{})
+			isDir = err == nil && full != nil && full.IsDir()
+		}
+		if isDir {
+			names = append(names, fi[i].Name()+dirSuffix)
+		} else {
+			names = append(names, fi[i].Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d *filestore) List(prefix, marker string, limit int64) ([]*Object, error) {
+	if marker != d.lastListed || d.listing == nil {
+		listed := make(chan *Object, 10240)
+		go func() {
+			d.listerr = d.Walk(func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				key := path[len(d.root):]
+				if key >= marker && strings.HasPrefix(key, prefix) && !info.IsDir() {
+					listed <- &Object{key, info.Size(), info.ModTime()}
+				}
+				return nil
+			})
+			close(listed)
+		}()
+		d.listing = listed
+	}
+	var objs []*Object
+	for len(objs) < int(limit) {
+		obj := <-d.listing
+		if obj == nil {
+			break
+		}
+		if obj.Key >= marker {
+			objs = append(objs, obj)
+		}
+	}
+	if len(objs) == 0 {
+		d.listing = nil
+		err := d.listerr
+		d.listerr = nil
+		return nil, err
+	}
+	d.lastListed = objs[len(objs)-1].Key
+	return objs, nil
+}
+
+func (d *filestore) Chtimes(path string, mtime time.Time) error {
+	return d.fs.Chtimes(filepath.Join(d.root, path), mtime, mtime)
+}
+
+func newDisk(root, accesskey, secretkey string) ObjectStorage {
+	os.MkdirAll(root, 0755)
+	return &filestore{fs: osFS{}, root: root}
+}
+
+func newMem(root, accesskey, secretkey string) ObjectStorage {
+	if root == "" {
+		root = "/"
+	}
+	fs := newMemFS()
+	fs.MkdirAll(root, 0755)
+	return &filestore{fs: fs, root: root}
+}
+
+func init() {
+	register("file", newDisk)
+	register("mem", newMem)
+}
@@ -0,0 +1,21 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+//go:build !windows
+// +build !windows
+
+package object
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeOf returns the inode number backing info, used to break symlink
+// cycles while walking. On platforms without inode numbers, see
+// file_windows.go.
+func inodeOf(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,15 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package object
+
+import "os"
+
+// inodeOf has no equivalent of a Unix inode to key off of on Windows. It
+// always returns 0, which means cycle detection (keyed by this value)
+// cannot distinguish between distinct directories reached via symlinks on
+// this platform; a walk with more than one symlinked directory may
+// under-traverse. The default SymlinksSafe follows symlinks, so this
+// affects every such walk on Windows unless SymlinksNone is set.
+func inodeOf(info os.FileInfo) uint64 {
+	return 0
+}
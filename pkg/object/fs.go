@@ -0,0 +1,70 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package object
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// File is the subset of *os.File that filestore needs from whatever it
+// opens through an FS.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	io.Seeker
+	// Truncate sets the file's length to size, as *os.File.Truncate does.
+	// RangePatcher uses it to drop trailing bytes a reassembled object no
+	// longer needs.
+	Truncate(size int64) error
+}
+
+// FS is the filesystem filestore is built on. It is deliberately narrow
+// (afero-shaped, not afero itself) so that alternative backends are cheap
+// to write: osFS for the real disk, memFS for tests, overlayFS to layer two
+// backends into one read-only view. Wrapping an FS is also
+// how encryption or compression would be added, without touching filestore
+// itself.
+type FS interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	// OpenFile opens an existing name for reading and writing without
+	// truncating it, so a caller can Seek to an offset and overwrite part
+	// of it in place (see RangePatcher).
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Readdir(name string) ([]os.FileInfo, error)
+	Chtimes(name string, atime, mtime time.Time) error
+	Remove(name string) error
+	Readlink(name string) (string, error)
+}
+
+// LocalPathFS is implemented by FS backends whose names map 1:1 onto real
+// paths on the host filesystem (osFS does; memFS and overlayFS don't).
+// Callers that need OS-only facilities unavailable through FS itself, such
+// as extended attributes, can use this to get a real path to operate on,
+// and should treat its absence as "not supported" rather than an error.
+type LocalPathFS interface {
+	FS
+	RealPath(name string) string
+}
+
+// RangePatcher is implemented by ObjectStorage backends that can overwrite
+// part of an existing object in place, so that reassembling a large file
+// from a content-defined chunk diff (see pkg/sync/chunk) doesn't require
+// rewriting the parts that didn't change.
+type RangePatcher interface {
+	// PatchRange overwrites dst starting at offset with src, creating dst
+	// first if it doesn't already exist.
+	PatchRange(dst string, offset int64, src io.Reader) error
+	// Truncate sets dst's length to size, dropping any trailing bytes left
+	// over from a previous, longer version of the object (PatchRange only
+	// ever overwrites in place, so a source that shrank needs this to avoid
+	// leaving stale bytes past the new end).
+	Truncate(dst string, size int64) error
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package object
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS is an in-memory FS, registered as the `mem://` store so that sync
+// pipelines (and anything else built against ObjectStorage) can be
+// exercised in tests, or as a scratch DST for a dry run, without touching
+// disk.
+type memFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	isDir   bool
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{nodes: map[string]*memNode{"/": {isDir: true, mode: os.ModeDir | 0755}}}
+}
+
+func memClean(name string) string {
+	name = path.Clean("/" + name)
+	return name
+}
+
+func (fs *memFS) ensureParents(name string) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if n, ok := fs.nodes[dir]; ok && n.isDir {
+			return
+		}
+		fs.nodes[dir] = &memNode{isDir: true, mode: os.ModeDir | 0755, modTime: time.Now()}
+		if dir == "/" {
+			return
+		}
+	}
+}
+
+func (fs *memFS) Open(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[memClean(name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &memFile{data: append([]byte(nil), n.data...)}, nil
+}
+
+func (fs *memFS) Create(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	fs.ensureParents(name)
+	n := &memNode{modTime: time.Now()}
+	fs.nodes[name] = n
+	return &memFile{onClose: func(data []byte) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		n.data = data
+		n.modTime = time.Now()
+	}}, nil
+}
+
+func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	n, ok := fs.nodes[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		fs.ensureParents(name)
+		n = &memNode{modTime: time.Now(), mode: perm}
+		fs.nodes[name] = n
+	}
+	data := n.data
+	if flag&os.O_TRUNC != 0 {
+		data = nil
+	}
+	return &memFile{data: append([]byte(nil), data...), onClose: func(d []byte) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		n.data = d
+		n.modTime = time.Now()
+	}}, nil
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[memClean(name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFileInfo{name: path.Base(memClean(name)), node: n}, nil
+}
+
+// Lstat never follows symlinks, but memFS has none, so it's Stat.
+func (fs *memFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+func (fs *memFS) Mkdir(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	if _, ok := fs.nodes[name]; ok {
+		return os.ErrExist
+	}
+	fs.nodes[name] = &memNode{isDir: true, mode: os.ModeDir | perm, modTime: time.Now()}
+	return nil
+}
+
+func (fs *memFS) MkdirAll(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	for dir := name; ; dir = path.Dir(dir) {
+		if n, ok := fs.nodes[dir]; ok {
+			if !n.isDir {
+				return os.ErrExist
+			}
+			break
+		}
+		fs.nodes[dir] = &memNode{isDir: true, mode: os.ModeDir | perm, modTime: time.Now()}
+		if dir == "/" {
+			break
+		}
+	}
+	return nil
+}
+
+func (fs *memFS) Readdir(name string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	if n, ok := fs.nodes[name]; !ok || !n.isDir {
+		return nil, os.ErrNotExist
+	}
+	prefix := name
+	if prefix != "/" {
+		prefix += "/"
+	}
+	var infos []os.FileInfo
+	for p, n := range fs.nodes {
+		if p == name || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if strings.Contains(strings.TrimPrefix(p, prefix), "/") {
+			continue // not a direct child
+		}
+		infos = append(infos, memFileInfo{name: path.Base(p), node: n})
+	}
+	return infos, nil
+}
+
+func (fs *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, ok := fs.nodes[memClean(name)]
+	if !ok {
+		return os.ErrNotExist
+	}
+	n.modTime = mtime
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = memClean(name)
+	if _, ok := fs.nodes[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.nodes, name)
+	return nil
+}
+
+func (fs *memFS) Readlink(name string) (string, error) {
+	return "", errors.New("memFS: symlinks are not supported")
+}
+
+type memFileInfo struct {
+	name string
+	node *memNode
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.node.data)) }
+func (i memFileInfo) Mode() os.FileMode  { return i.node.mode }
+func (i memFileInfo) ModTime() time.Time { return i.node.modTime }
+func (i memFileInfo) IsDir() bool        { return i.node.isDir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+// memFile is the File a memFS hands back from Open/Create/OpenFile: an
+// in-memory buffer with a cursor. onClose, if set (i.e. this came from
+// Create or OpenFile),
+// writes the final buffer back into the owning memFS.
+type memFile struct {
+	data    []byte
+	pos     int64
+	onClose func(data []byte)
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if need := f.pos + int64(len(p)); need > int64(len(f.data)) {
+		grown := make([]byte, need)
+		copy(grown, f.data)
+		f.data = grown
+	}
+	n := copy(f.data[f.pos:], p)
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = offset
+	case io.SeekCurrent:
+		f.pos += offset
+	case io.SeekEnd:
+		f.pos = int64(len(f.data)) + offset
+	}
+	return f.pos, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	switch {
+	case size < int64(len(f.data)):
+		f.data = f.data[:size]
+	case size > int64(len(f.data)):
+		grown := make([]byte, size)
+		copy(grown, f.data)
+		f.data = grown
+	}
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.onClose != nil {
+		f.onClose(f.data)
+	}
+	return nil
+}
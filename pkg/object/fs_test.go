@@ -0,0 +1,171 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package object
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemFSCreateOpenRoundtrip(t *testing.T) {
+	fs := newMemFS()
+	f, err := fs.Create("/dir/a")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	r, err := fs.Open("/dir/a")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("read %q, want %q", got, "hello")
+	}
+
+	fi, err := fs.Stat("/dir")
+	if err != nil {
+		t.Fatalf("Stat(/dir): %s", err)
+	}
+	if !fi.IsDir() {
+		t.Fatalf("Create didn't materialize /dir as a directory")
+	}
+}
+
+func TestMemFSOpenFileTruncate(t *testing.T) {
+	fs := newMemFS()
+	f, _ := fs.Create("/a")
+	f.Write([]byte("0123456789"))
+	f.Close()
+
+	rw, err := fs.OpenFile("/a", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if err := rw.Truncate(4); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+	rw.Close()
+
+	fi, err := fs.Stat("/a")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Size() != 4 {
+		t.Fatalf("size after truncate = %d, want 4", fi.Size())
+	}
+}
+
+func TestOverlayFSPrefersUpperOnClash(t *testing.T) {
+	upper, lower := newMemFS(), newMemFS()
+	wf := func(fs FS, name, data string) {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%s): %s", name, err)
+		}
+		f.Write([]byte(data))
+		f.Close()
+	}
+	wf(upper, "/a", "upper")
+	wf(lower, "/a", "lower")
+	wf(lower, "/b", "lower-only")
+
+	ov := newOverlayFS(upper, lower)
+	r, err := ov.Open("/a")
+	if err != nil {
+		t.Fatalf("Open(/a): %s", err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != "upper" {
+		t.Fatalf("overlay read %q for a clashing name, want upper's %q", got, "upper")
+	}
+
+	r, err = ov.Open("/b")
+	if err != nil {
+		t.Fatalf("Open(/b): %s", err)
+	}
+	got, _ = ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != "lower-only" {
+		t.Fatalf("overlay didn't fall back to lower for a name only lower has")
+	}
+
+	if err := ov.Remove("/a"); err != errOverlayReadOnly {
+		t.Fatalf("Remove on overlayFS = %v, want errOverlayReadOnly", err)
+	}
+}
+
+func TestOverlayFSReaddirMerges(t *testing.T) {
+	upper, lower := newMemFS(), newMemFS()
+	upper.Create("/a")
+	lower.Create("/a")
+	lower.Create("/b")
+
+	ov := newOverlayFS(upper, lower)
+	entries, err := ov.Readdir("/")
+	if err != nil {
+		t.Fatalf("Readdir: %s", err)
+	}
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	if !names["a"] || !names["b"] {
+		t.Fatalf("Readdir merged = %v, want both a and b", names)
+	}
+}
+
+func TestNewOverlayIsUsableAsAStore(t *testing.T) {
+	upperRoot := t.TempDir()
+	lowerRoot := t.TempDir()
+	if err := ioutil.WriteFile(lowerRoot+"/base", []byte("from-lower"), 0644); err != nil {
+		t.Fatalf("write base: %s", err)
+	}
+
+	store := newOverlay(upperRoot+","+lowerRoot, "", "")
+	r, err := store.Get("/base", 0, -1)
+	if err != nil {
+		t.Fatalf("Get through overlay://: %s", err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != "from-lower" {
+		t.Fatalf("overlay:// read %q, want %q", got, "from-lower")
+	}
+
+	if err := store.Put("/new", strings.NewReader("x")); err == nil {
+		t.Fatalf("overlay:// accepted a write; it should be read-only")
+	}
+}
+
+func TestFilestoreOverMemFSRoundtrip(t *testing.T) {
+	store := newMem("", "", "")
+	if err := store.Put("/a/b", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	r, err := store.Get("/a/b", 0, -1)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("read %q through filestore+memFS, want %q", got, "hello")
+	}
+}
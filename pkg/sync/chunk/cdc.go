@@ -0,0 +1,157 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+// Package chunk implements content-defined chunking for large-file sync:
+// splitting a file into chunks whose boundaries depend on its content (not
+// its offset), diffing two such chunkings, and reassembling only the
+// chunks that changed at the destination. This lets `juicefs sync` avoid
+// re-transferring a whole multi-gigabyte file when only part of it changed,
+// the same way rsync's rolling checksum does, but keyed off stored chunk
+// digests instead of a live two-way handshake.
+package chunk
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/object/contenthash/digest"
+)
+
+// DefaultAvgSize is used when Options leaves AvgSize at zero. It mirrors a
+// typical FastCDC preset: small enough that a single changed byte only
+// invalidates a couple of chunks, large enough to keep the manifest and
+// the number of ranged requests small.
+const DefaultAvgSize = 8 << 20 // 8MiB
+
+// minSizeRatio and maxSizeRatio bound a chunk relative to AvgSize, since
+// the mask-driven cut point only lands every AvgSize bytes on average. If
+// MinSize/MaxSize were left as fixed 2MiB/32MiB regardless of AvgSize, an
+// AvgSize pushed above 32MiB or below 2MiB would put the average cut point
+// outside those fixed bounds entirely, so every chunk would hit MaxSize
+// (or never clear MinSize) and chunking would degenerate into fixed-size
+// blocks — exactly what content-defined chunking exists to avoid.
+const (
+	minSizeRatio = 4
+	maxSizeRatio = 4
+)
+
+// Options configures the chunker. The zero value means "use the defaults".
+type Options struct {
+	MinSize int64
+	AvgSize int64
+	MaxSize int64
+}
+
+func (o Options) withDefaults() Options {
+	if o.AvgSize == 0 {
+		o.AvgSize = DefaultAvgSize
+	}
+	if o.MinSize == 0 {
+		o.MinSize = o.AvgSize / minSizeRatio
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = o.AvgSize * maxSizeRatio
+	}
+	return o
+}
+
+// Chunk is one content-defined slice of a file.
+type Chunk struct {
+	Offset int64         `json:"offset"`
+	Length int64         `json:"length"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// gearTable maps each possible byte value to a pseudo-random 64-bit value,
+// used by the rolling hash below (the same "gear hash" construction used
+// by restic's and casync's chunkers). It is seeded deterministically so
+// that the same bytes always cut into the same chunks, run to run and
+// process to process — the manifest would be useless otherwise.
+var gearTable [256]uint64
+
+func init() {
+	rnd := rand.New(rand.NewSource(0x6a756963656673)) // "juicefs" as hex, just a fixed seed
+	for i := range gearTable {
+		gearTable[i] = rnd.Uint64()
+	}
+}
+
+// maskFor returns the bitmask a gear hash must be all-zero under, on
+// average, once every avgSize bytes: picking the N low bits that make
+// 2^N ~= avgSize.
+func maskFor(avgSize int64) uint64 {
+	bits := uint(0)
+	for int64(1)<<bits < avgSize {
+		bits++
+	}
+	return 1<<bits - 1
+}
+
+// Split streams key out of store with a single Get(key, 0, -1) and splits it into
+// content-defined chunks. Boundaries fall where the low bits of a rolling
+// gear hash over the last few bytes are all zero, which happens on average
+// every opts.AvgSize bytes; MinSize/MaxSize bound how close two boundaries
+// can be so a pathological file can't produce chunks that are too small or
+// too large to be worth ranging over.
+func Split(store object.ObjectStorage, key string, opts Options) ([]Chunk, error) {
+	opts = opts.withDefaults()
+	mask := maskFor(opts.AvgSize)
+
+	rc, err := store.Get(key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	r := bufio.NewReaderSize(rc, 1<<20)
+
+	var chunks []Chunk
+	var offset int64
+	for {
+		length, sum, err := nextChunk(r, mask, opts.MinSize, opts.MaxSize)
+		if length > 0 {
+			chunks = append(chunks, Chunk{Offset: offset, Length: length, Digest: sum})
+			offset += length
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// nextChunk consumes one chunk's worth of bytes from r and returns its
+// length and digest. io.EOF (returned as err) signals the stream is done;
+// the caller still uses a non-zero length returned alongside it.
+func nextChunk(r *bufio.Reader, mask uint64, minSize, maxSize int64) (int64, digest.Digest, error) {
+	h := sha256.New()
+	var hash uint64
+	var n int64
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if n == 0 {
+				return 0, digest.Digest{}, err
+			}
+			var d digest.Digest
+			copy(d[:], h.Sum(nil))
+			return n, d, err
+		}
+		h.Write([]byte{b})
+		n++
+		hash = (hash << 1) + gearTable[b]
+		if n >= minSize && hash&mask == 0 {
+			var d digest.Digest
+			copy(d[:], h.Sum(nil))
+			return n, d, nil
+		}
+		if n >= maxSize {
+			var d digest.Digest
+			copy(d[:], h.Sum(nil))
+			return n, d, nil
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package chunk
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+func newMemStore(t *testing.T) object.ObjectStorage {
+	t.Helper()
+	store, err := object.CreateStorage("mem", "", "", "")
+	if err != nil {
+		t.Fatalf("CreateStorage(mem): %s", err)
+	}
+	return store
+}
+
+func TestOptionsWithDefaultsDerivesMinMaxFromAvgSize(t *testing.T) {
+	got := Options{AvgSize: 16 << 20}.withDefaults()
+	want := Options{MinSize: 4 << 20, AvgSize: 16 << 20, MaxSize: 64 << 20}
+	if got != want {
+		t.Fatalf("withDefaults(AvgSize=16MiB) = %+v, want %+v", got, want)
+	}
+}
+
+func TestOptionsWithDefaultsZeroValue(t *testing.T) {
+	got := Options{}.withDefaults()
+	want := Options{MinSize: DefaultAvgSize / 4, AvgSize: DefaultAvgSize, MaxSize: DefaultAvgSize * 4}
+	if got != want {
+		t.Fatalf("withDefaults(zero value) = %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitIsDeterministic(t *testing.T) {
+	store := newMemStore(t)
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog ", 1<<12)
+	if err := store.Put("/f", strings.NewReader(content)); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	opts := Options{AvgSize: 16 << 10}
+	a, err := Split(store, "/f", opts)
+	if err != nil {
+		t.Fatalf("Split: %s", err)
+	}
+	b, err := Split(store, "/f", opts)
+	if err != nil {
+		t.Fatalf("Split (again): %s", err)
+	}
+	if len(a) == 0 {
+		t.Fatalf("Split produced no chunks for a non-empty file")
+	}
+	if len(a) != len(b) {
+		t.Fatalf("Split produced %d chunks the first time, %d the second", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("chunk %d differs between identical splits: %+v != %+v", i, a[i], b[i])
+		}
+	}
+}
+
+func TestDiffOnlyFlagsNewContent(t *testing.T) {
+	store := newMemStore(t)
+	// Fixed-seed pseudo-random content: a short repeating pattern would have
+	// no content-defined cut points at all, so every chunk would end at
+	// MaxSize and the insertion would shift all of them.
+	rnd := rand.New(rand.NewSource(1))
+	buf := make([]byte, 1<<18)
+	for i := range buf {
+		buf[i] = 'a' + byte(rnd.Intn(26))
+	}
+	content := string(buf)
+	if err := store.Put("/f", strings.NewReader(content)); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	opts := Options{AvgSize: 8 << 10}
+	prevChunks, err := Split(store, "/f", opts)
+	if err != nil {
+		t.Fatalf("Split: %s", err)
+	}
+	prev := &Manifest{Chunks: prevChunks}
+
+	// Insert bytes near the middle: content-defined chunking should only
+	// invalidate the chunk(s) touching the insertion, not every chunk after
+	// it the way fixed-size chunking would.
+	mid := len(content) / 2
+	changed := content[:mid] + "INSERTED-BYTES" + content[mid:]
+	if err := store.Put("/f", strings.NewReader(changed)); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	nextChunks, err := Split(store, "/f", opts)
+	if err != nil {
+		t.Fatalf("Split (changed): %s", err)
+	}
+	next := &Manifest{Chunks: nextChunks}
+
+	diff := Diff(prev, next)
+	if len(diff) == 0 {
+		t.Fatalf("Diff found no changed chunks after an insertion")
+	}
+	if len(diff) == len(nextChunks) {
+		t.Fatalf("Diff flagged every chunk (%d) as new; content-defined chunking should have kept most boundaries stable", len(diff))
+	}
+}
+
+func TestSyncFileReassemblesChangedContentOnly(t *testing.T) {
+	src := newMemStore(t)
+	dst := newMemStore(t)
+	opts := Options{AvgSize: 8 << 10}
+
+	original := strings.Repeat("0123456789", 1<<12)
+	if err := src.Put("/f", strings.NewReader(original)); err != nil {
+		t.Fatalf("Put src: %s", err)
+	}
+	if err := SyncFile(src, dst, "/f", opts); err != nil {
+		t.Fatalf("SyncFile (initial): %s", err)
+	}
+	assertContent(t, dst, "/f", original)
+
+	mid := len(original) / 2
+	changed := original[:mid] + "CHANGED" + original[mid+len("CHANGED"):]
+	if err := src.Put("/f", strings.NewReader(changed)); err != nil {
+		t.Fatalf("Put src (changed): %s", err)
+	}
+	if err := SyncFile(src, dst, "/f", opts); err != nil {
+		t.Fatalf("SyncFile (update): %s", err)
+	}
+	assertContent(t, dst, "/f", changed)
+}
+
+func assertContent(t *testing.T, store object.ObjectStorage, key, want string) {
+	t.Helper()
+	r, err := store.Get(key, 0, -1)
+	if err != nil {
+		t.Fatalf("Get(%s): %s", key, err)
+	}
+	defer r.Close()
+	var buf []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(buf) != want {
+		t.Fatalf("content at %s has length %d, want length %d", key, len(buf), len(want))
+	}
+}
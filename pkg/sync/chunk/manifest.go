@@ -0,0 +1,180 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package chunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/juicedata/juicefs/pkg/object"
+	"github.com/juicedata/juicefs/pkg/object/contenthash/digest"
+)
+
+// ManifestSuffix is appended to an object's key to get the key its chunk
+// manifest is stored under. It's object.ManifestSuffix under the hood so
+// that filestore.Walk/List and contenthash.ChecksumTree recognize manifest
+// keys as sync bookkeeping the same way they recognize object.SidecarDir.
+const ManifestSuffix = object.ManifestSuffix
+
+// Manifest records the chunk boundaries and digests a destination last
+// observed for an object, so a later sync can tell which chunks changed
+// without re-reading the whole file.
+type Manifest struct {
+	Key    string  `json:"key"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// ManifestKey returns the sidecar key key's chunk manifest is stored under.
+// Callers that exclude key from a normal sync pass (because SyncFile
+// already handled it) need to exclude this too, or a plain sync --delete-dst
+// run will see it as extraneous at dst and delete it.
+func ManifestKey(key string) string {
+	return key + ManifestSuffix
+}
+
+// LoadManifest reads key's manifest from store. It returns a nil Manifest
+// (not an error) if none exists yet, since that's the expected state the
+// first time a file is chunk-synced. Any other error from store (a
+// transient network or I/O failure, say) is propagated rather than treated
+// as "no manifest", since silently swallowing it would make SyncFile redo
+// a full chunk re-transfer instead of surfacing the failure.
+func LoadManifest(store object.ObjectStorage, key string) (*Manifest, error) {
+	r, err := store.Get(ManifestKey(key), 0, -1)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SaveManifest persists m to store as key's sidecar manifest object.
+func SaveManifest(store object.ObjectStorage, key string, m *Manifest) error {
+	m.Key = key
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return store.Put(ManifestKey(key), bytes.NewReader(buf))
+}
+
+// Diff returns the chunks of next whose content doesn't exist anywhere in
+// prev, matched by digest rather than offset: a single insert or delete
+// shifts every following chunk's boundary without changing its content,
+// which is exactly the case content-defined chunking exists to tolerate.
+// These are the chunks SyncFile has no choice but to fetch from src; every
+// other chunk already exists somewhere at dst (possibly under a different
+// offset) and is relocated there instead of re-transferred.
+func Diff(prev *Manifest, next *Manifest) []Chunk {
+	have := make(map[digest.Digest]bool)
+	if prev != nil {
+		for _, c := range prev.Chunks {
+			have[c.Digest] = true
+		}
+	}
+	var changed []Chunk
+	for _, c := range next.Chunks {
+		if !have[c.Digest] {
+			changed = append(changed, c)
+		}
+	}
+	return changed
+}
+
+// ErrNoRangePatch is returned by SyncFile when dst can't apply a ranged
+// patch in place; the caller should fall back to a whole-file Copy.
+var ErrNoRangePatch = errors.New("chunk: destination does not support ranged patches")
+
+// SyncFile content-defined-chunk-syncs key from src to dst: it splits src
+// into chunks and diffs them against dst's last-known manifest (if any) by
+// digest. A chunk already sitting at the right offset is left alone; one
+// whose content exists at dst under a different offset is relocated there
+// with a local read+patch (no trip to src); everything else is fetched
+// from src. dst is then truncated to src's length and the new manifest is
+// saved. dst must implement object.RangePatcher, since without one there
+// is no way to write part of an existing object without rewriting all of
+// it — callers without that should use a plain Copy instead.
+func SyncFile(src, dst object.ObjectStorage, key string, opts Options) error {
+	patcher, ok := dst.(object.RangePatcher)
+	if !ok {
+		return ErrNoRangePatch
+	}
+
+	next, err := Split(src, key, opts)
+	if err != nil {
+		return err
+	}
+	prev, err := LoadManifest(dst, key)
+	if err != nil {
+		return err
+	}
+
+	isNew := make(map[digest.Digest]bool)
+	for _, c := range Diff(prev, &Manifest{Chunks: next}) {
+		isNew[c.Digest] = true
+	}
+	byDigest := make(map[digest.Digest]Chunk)
+	if prev != nil {
+		for _, c := range prev.Chunks {
+			byDigest[c.Digest] = c
+		}
+	}
+
+	// Stage the bytes of every chunk dst already has under a different
+	// offset before patching anything in: PatchRange overwrites in place,
+	// so reading a relocated chunk's old location after an earlier write
+	// has clobbered it would corrupt the result.
+	staged := make(map[digest.Digest][]byte)
+	for _, c := range next {
+		old := byDigest[c.Digest]
+		if isNew[c.Digest] || old.Offset == c.Offset || staged[c.Digest] != nil {
+			continue
+		}
+		r, err := dst.Get(key, old.Offset, old.Length)
+		if err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadAll(io.LimitReader(r, old.Length))
+		r.Close()
+		if err != nil {
+			return err
+		}
+		staged[c.Digest] = buf
+	}
+
+	var size int64
+	for _, c := range next {
+		size = c.Offset + c.Length
+		if old, ok := byDigest[c.Digest]; ok && old.Offset == c.Offset {
+			continue // identical content already sitting at the right place
+		}
+		if buf, ok := staged[c.Digest]; ok {
+			if err := patcher.PatchRange(key, c.Offset, bytes.NewReader(buf)); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := src.Get(key, c.Offset, c.Length)
+		if err != nil {
+			return err
+		}
+		err = patcher.PatchRange(key, c.Offset, io.LimitReader(r, c.Length))
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := patcher.Truncate(key, size); err != nil {
+		return err
+	}
+	return SaveManifest(dst, key, &Manifest{Chunks: next})
+}
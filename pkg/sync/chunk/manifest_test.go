@@ -0,0 +1,62 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package chunk
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/juicedata/juicefs/pkg/object"
+)
+
+func TestLoadManifestReturnsNilForMissingManifest(t *testing.T) {
+	store := newMemStore(t)
+	m, err := LoadManifest(store, "/f")
+	if err != nil {
+		t.Fatalf("LoadManifest: %s", err)
+	}
+	if m != nil {
+		t.Fatalf("LoadManifest = %+v, want nil for a key with no manifest yet", m)
+	}
+}
+
+func TestLoadManifestRoundtrip(t *testing.T) {
+	store := newMemStore(t)
+	want := &Manifest{Chunks: []Chunk{{Offset: 0, Length: 10}}}
+	if err := SaveManifest(store, "/f", want); err != nil {
+		t.Fatalf("SaveManifest: %s", err)
+	}
+	got, err := LoadManifest(store, "/f")
+	if err != nil {
+		t.Fatalf("LoadManifest: %s", err)
+	}
+	if got == nil || len(got.Chunks) != 1 || got.Chunks[0] != want.Chunks[0] {
+		t.Fatalf("LoadManifest = %+v, want %+v", got, want)
+	}
+}
+
+var errTransient = errors.New("transient backend failure")
+
+// failingGetStore wraps a store so Get always fails with a non-not-exist
+// error, the way a transient network or I/O failure on a real backend
+// would. LoadManifest must surface this rather than treat it as "no
+// manifest yet" (see its doc comment).
+type failingGetStore struct {
+	object.ObjectStorage
+}
+
+func (failingGetStore) Get(key string, off, limit int64) (io.ReadCloser, error) {
+	return nil, errTransient
+}
+
+func TestLoadManifestPropagatesNonNotExistErrors(t *testing.T) {
+	store := newMemStore(t)
+	_, err := LoadManifest(failingGetStore{store}, "/f")
+	if err == nil {
+		t.Fatalf("LoadManifest swallowed a non-not-exist error")
+	}
+	if err != errTransient {
+		t.Fatalf("LoadManifest returned %v, want the underlying error %v", err, errTransient)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2018-present Juicedata Inc.
+
+package chunk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeSuffixes maps a --chunk-threshold/--chunk-avg-size suffix to its
+// byte multiplier, ordered longest-first so e.g. "MIB" is matched before
+// the "B" it also ends with.
+var sizeSuffixes = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a size such as "64M" or "2GiB" into a byte count. A
+// trailing K/M/G/T (binary powers of 1024) is optional; "B"/"iB" suffixes
+// are accepted but not required.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("chunk: empty size")
+	}
+	upper := strings.ToUpper(s)
+	mult := int64(1)
+	for _, sx := range sizeSuffixes {
+		if strings.HasSuffix(upper, sx.suffix) {
+			mult = sx.mult
+			s = s[:len(s)-len(sx.suffix)]
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("chunk: invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}